@@ -0,0 +1,150 @@
+package treqs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authenticator authorizes a request before the named action is
+// allowed to run. action is the lower-cased action header value (the
+// empty string for a plain passthrough request); key is the value of
+// the X-Treqs-Key header, extracted before it's stripped from r.
+type Authenticator interface {
+	Authorize(r *http.Request, action, key string) error
+}
+
+// StaticKeyAuthenticator authorizes a request if its key matches a
+// single shared secret sent in plaintext. It's the original treqs
+// behavior, fine for localhost but unsafe anywhere else; Tracer falls
+// back to it when Auth is nil.
+type StaticKeyAuthenticator string
+
+func (a StaticKeyAuthenticator) Authorize(r *http.Request, action, key string) error {
+	if key != string(a) {
+		return errors.New("treqs: invalid key")
+	}
+	return nil
+}
+
+// HMACAuthenticator authorizes requests carrying a short-lived,
+// HMAC-signed token in place of a static shared key. The token is
+// "<unix-timestamp>.<hex-hmac-sha256>", where the signature covers
+// "<timestamp>|<action>|<path>".
+type HMACAuthenticator struct {
+	Secret []byte
+
+	// Skew bounds how far a token's timestamp may drift from now in
+	// either direction. Defaults to 30s if zero.
+	Skew time.Duration
+
+	// Allow, if non-empty, lists the only actions a verified token may
+	// be used for.
+	Allow []string
+}
+
+func (a *HMACAuthenticator) Authorize(r *http.Request, action, key string) error {
+	if len(a.Allow) > 0 && !contains(a.Allow, action) {
+		return fmt.Errorf("treqs: action %q is not allowed", action)
+	}
+
+	ts, sig, ok := strings.Cut(key, ".")
+	if !ok {
+		return errors.New("treqs: malformed token")
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("treqs: malformed token timestamp")
+	}
+
+	skew := a.Skew
+	if skew == 0 {
+		skew = 30 * time.Second
+	}
+	if age := time.Since(time.Unix(sec, 0)); age < -skew || age > skew {
+		return errors.New("treqs: token timestamp outside of allowed skew")
+	}
+
+	mac := hmac.New(sha256.New, a.Secret)
+	fmt.Fprintf(mac, "%s|%s|%s", ts, action, r.URL.Path)
+
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("treqs: invalid token signature")
+	}
+
+	return nil
+}
+
+// HMACToken builds the token an HMACAuthenticator with the same
+// secret will accept for action & path, as of at.
+func HMACToken(secret []byte, action, path string, at time.Time) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s|%s|%s", ts, action, path)
+
+	return ts + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// MTLSAuthenticator authorizes a request using its client certificate
+// chain instead of a shared secret.
+type MTLSAuthenticator struct {
+	// CAs lists the certificate authorities a client certificate chain
+	// must terminate at. r.TLS.VerifiedChains is already the result of
+	// the listener's own verification; CAs narrows that down to the
+	// roots this tracer trusts.
+	CAs []*x509.Certificate
+
+	// Allow, if non-empty, restricts authorization to these client
+	// certificate Common Names.
+	Allow []string
+}
+
+func (a *MTLSAuthenticator) Authorize(r *http.Request, action, key string) error {
+	if r.TLS == nil {
+		return errors.New("treqs: request has no TLS connection state")
+	}
+
+	for _, chain := range r.TLS.VerifiedChains {
+		if len(chain) == 0 {
+			continue
+		}
+
+		if !a.trusted(chain[len(chain)-1]) {
+			continue
+		}
+
+		if leaf := chain[0]; len(a.Allow) == 0 || contains(a.Allow, leaf.Subject.CommonName) {
+			return nil
+		}
+	}
+
+	return errors.New("treqs: no verified client certificate chains to a trusted CA")
+}
+
+func (a *MTLSAuthenticator) trusted(root *x509.Certificate) bool {
+	for _, ca := range a.CAs {
+		if ca.Equal(root) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
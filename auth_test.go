@@ -0,0 +1,183 @@
+package treqs
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStaticKeyAuthenticator(t *testing.T) {
+	auth := StaticKeyAuthenticator("secret")
+
+	if err := auth.Authorize(nil, "trace", "secret"); err != nil {
+		t.Fatalf("Authorize with the correct key returned %v", err)
+	}
+	if err := auth.Authorize(nil, "trace", "wrong"); err == nil {
+		t.Fatal("Authorize with the wrong key returned nil")
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	secret := []byte("shared-secret")
+	auth := &HMACAuthenticator{Secret: secret}
+
+	now := time.Now()
+	token := HMACToken(secret, "trace", "/x", now)
+
+	if err := auth.Authorize(&http.Request{URL: mustURL(t, "/x")}, "trace", token); err != nil {
+		t.Fatalf("Authorize with a valid token returned %v", err)
+	}
+
+	if err := auth.Authorize(&http.Request{URL: mustURL(t, "/x")}, "read", token); err == nil {
+		t.Fatal("Authorize accepted a token signed for a different action")
+	}
+
+	if err := auth.Authorize(&http.Request{URL: mustURL(t, "/y")}, "trace", token); err == nil {
+		t.Fatal("Authorize accepted a token signed for a different path")
+	}
+
+	otherToken := HMACToken([]byte("other-secret"), "trace", "/x", now)
+	if err := auth.Authorize(&http.Request{URL: mustURL(t, "/x")}, "trace", otherToken); err == nil {
+		t.Fatal("Authorize accepted a token signed with the wrong secret")
+	}
+
+	staleToken := HMACToken(secret, "trace", "/x", now.Add(-time.Hour))
+	if err := auth.Authorize(&http.Request{URL: mustURL(t, "/x")}, "trace", staleToken); err == nil {
+		t.Fatal("Authorize accepted a token outside the skew window")
+	}
+
+	if err := auth.Authorize(&http.Request{URL: mustURL(t, "/x")}, "trace", "garbage"); err == nil {
+		t.Fatal("Authorize accepted a malformed token")
+	}
+}
+
+func TestHMACAuthenticatorAllow(t *testing.T) {
+	secret := []byte("shared-secret")
+	auth := &HMACAuthenticator{Secret: secret, Allow: []string{"read"}}
+
+	token := HMACToken(secret, "trace", "/x", time.Now())
+	if err := auth.Authorize(&http.Request{URL: mustURL(t, "/x")}, "trace", token); err == nil {
+		t.Fatal("Authorize accepted an action outside of Allow")
+	}
+}
+
+func TestMTLSAuthenticator(t *testing.T) {
+	caKey, caCert := mustSelfSignedCA(t, "test-ca")
+	leaf := mustLeafCert(t, caKey, caCert, "client.example")
+
+	auth := &MTLSAuthenticator{CAs: []*x509.Certificate{caCert}}
+
+	req := &http.Request{TLS: &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{leaf, caCert}},
+	}}
+	if err := auth.Authorize(req, "trace", ""); err != nil {
+		t.Fatalf("Authorize with a chain to a trusted CA returned %v", err)
+	}
+
+	if err := auth.Authorize(&http.Request{}, "trace", ""); err == nil {
+		t.Fatal("Authorize accepted a request with no TLS connection state")
+	}
+
+	_, otherCACert := mustSelfSignedCA(t, "other-ca")
+	untrusted := &http.Request{TLS: &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{leaf, otherCACert}},
+	}}
+	if err := auth.Authorize(untrusted, "trace", ""); err == nil {
+		t.Fatal("Authorize accepted a chain terminating at an untrusted CA")
+	}
+}
+
+func TestMTLSAuthenticatorAllow(t *testing.T) {
+	caKey, caCert := mustSelfSignedCA(t, "test-ca")
+	leaf := mustLeafCert(t, caKey, caCert, "client.example")
+
+	auth := &MTLSAuthenticator{CAs: []*x509.Certificate{caCert}, Allow: []string{"other.example"}}
+
+	req := &http.Request{TLS: &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{leaf, caCert}},
+	}}
+	if err := auth.Authorize(req, "trace", ""); err == nil {
+		t.Fatal("Authorize accepted a CommonName outside of Allow")
+	}
+}
+
+func mustURL(t *testing.T, path string) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// mustSelfSignedCA generates an in-memory self-signed CA certificate
+// for use as a trust anchor in MTLSAuthenticator tests.
+func mustSelfSignedCA(t *testing.T, cn string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, cert
+}
+
+// mustLeafCert issues a leaf certificate signed by caKey/caCert with
+// the given Common Name.
+func mustLeafCert(t *testing.T, caKey *rsa.PrivateKey, caCert *x509.Certificate, cn string) *x509.Certificate {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
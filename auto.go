@@ -0,0 +1,150 @@
+package treqs
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+// ResponseSummary describes a completed response, passed to a
+// PostTrigger so it can decide whether a speculative trace is worth
+// keeping.
+type ResponseSummary struct {
+	Status   int
+	Duration time.Duration
+}
+
+// Stats summarizes a Tracer's automatic-tracing activity.
+type Stats struct {
+	// Skipped counts speculative traces dropped because another request
+	// held the lock when Trigger matched: a trace in progress, or any
+	// request being served under the shared lock.
+	Skipped uint64
+}
+
+// autoTrace speculatively captures a runtime trace for r. Unlike
+// trace(), which blocks until it can start, autoTrace uses TryLock so
+// a request matching Trigger never waits behind another trace; if the
+// lock is held by anyone (a trace, or requests served under the shared
+// lock) it's simply skipped and served normally.
+func (t *Tracer) autoTrace(w http.ResponseWriter, r *http.Request) {
+	// TryLock, not Lock: a request matching Trigger must never wait
+	// behind another trace, so the exclusive lock is held for the
+	// whole speculative capture (same discipline as trace()) rather
+	// than just the map write at the end, and contention just means
+	// this request is served normally instead.
+	if !t.mu.TryLock() {
+		t.skipped.Add(1)
+
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+
+		t.Handler.ServeHTTP(w, r)
+		return
+	}
+	defer t.mu.Unlock()
+
+	if t.traces == nil {
+		t.traces = make(map[string]profileBundle)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := trace.Start(buf); err != nil {
+		// Lost a race with another trace started between TryLock and
+		// here; fall back to serving normally rather than failing the
+		// request.
+		t.skipped.Add(1)
+		t.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	started := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	t.Handler.ServeHTTP(sw, r)
+	trace.Stop()
+
+	if t.PostTrigger == nil {
+		return
+	}
+
+	summary := &ResponseSummary{Status: sw.status, Duration: time.Since(started)}
+	if !t.PostTrigger(r, summary) {
+		return
+	}
+
+	id := randHex()
+	bundle := newProfileBundle()
+	bundle[profTrace] = buf
+	t.traces[id] = bundle
+
+	if t.Store != nil {
+		t.Store.Put(TraceMeta{
+			ID:         id,
+			CapturedAt: started,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     sw.status,
+		}, bytes.NewReader(buf.Bytes()))
+	}
+}
+
+func (t *Tracer) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Stats{Skipped: t.skipped.Load()})
+}
+
+// RateSampler returns a Trigger that fires for n requests per duration
+// on average: it starts with a burst allowance of n, which refills
+// steadily rather than all at once. Use it to
+// bound how often automatic tracing kicks in regardless of traffic
+// volume.
+func RateSampler(n int, per time.Duration) func(*http.Request) bool {
+	rate := float64(n) / per.Seconds()
+
+	var (
+		mu     sync.Mutex
+		tokens = float64(n)
+		last   = time.Now()
+	)
+
+	return func(*http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Now()
+		if tokens += now.Sub(last).Seconds() * rate; tokens > float64(n) {
+			tokens = float64(n)
+		}
+		last = now
+
+		if tokens < 1 {
+			return false
+		}
+		tokens--
+		return true
+	}
+}
+
+// LatencyTrigger returns a PostTrigger that keeps a speculative trace
+// only if the response took at least threshold to complete.
+func LatencyTrigger(threshold time.Duration) func(*http.Request, *ResponseSummary) bool {
+	return func(_ *http.Request, sum *ResponseSummary) bool {
+		return sum.Duration >= threshold
+	}
+}
+
+// StatusTrigger returns a PostTrigger that keeps a speculative trace
+// only if the response status matches one of statuses.
+func StatusTrigger(statuses ...int) func(*http.Request, *ResponseSummary) bool {
+	match := make(map[int]bool, len(statuses))
+	for _, status := range statuses {
+		match[status] = true
+	}
+
+	return func(_ *http.Request, sum *ResponseSummary) bool {
+		return match[sum.Status]
+	}
+}
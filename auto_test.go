@@ -0,0 +1,171 @@
+package treqs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateSampler(t *testing.T) {
+	sample := RateSampler(2, time.Minute)
+
+	if !sample(nil) || !sample(nil) {
+		t.Fatal("expected the first 2 requests within the burst to be sampled")
+	}
+	if sample(nil) {
+		t.Fatal("expected the 3rd request to exhaust the burst")
+	}
+}
+
+func TestLatencyTrigger(t *testing.T) {
+	trigger := LatencyTrigger(10 * time.Millisecond)
+
+	if trigger(nil, &ResponseSummary{Duration: 5 * time.Millisecond}) {
+		t.Fatal("got true for a response under threshold")
+	}
+	if !trigger(nil, &ResponseSummary{Duration: 15 * time.Millisecond}) {
+		t.Fatal("got false for a response over threshold")
+	}
+}
+
+func TestStatusTrigger(t *testing.T) {
+	trigger := StatusTrigger(http.StatusInternalServerError, http.StatusBadGateway)
+
+	if trigger(nil, &ResponseSummary{Status: http.StatusOK}) {
+		t.Fatal("got true for a non-matching status")
+	}
+	if !trigger(nil, &ResponseSummary{Status: http.StatusBadGateway}) {
+		t.Fatal("got false for a matching status")
+	}
+}
+
+// TestAutoTraceConcurrentWithReset exercises autoTrace's speculative
+// capture (mu held for the whole thing, per the trace() discipline)
+// concurrently with reset's exclusive map replacement, under the race
+// detector.
+func TestAutoTraceConcurrentWithReset(t *testing.T) {
+	tracer := &Tracer{
+		Key:         "treqs",
+		Trigger:     func(*http.Request) bool { return true },
+		PostTrigger: func(*http.Request, *ResponseSummary) bool { return true },
+		Handler:     http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := http.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+		}()
+	}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Set(xTReqsKey, "treqs")
+			req.Header.Set(xTReqsAction, "reset")
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+		}()
+	}
+
+	wg.Wait()
+}
+
+// TestAutoTraceStats forces a real TryLock contention between two
+// concurrent Trigger-matched requests, then checks the stats action
+// reports the resulting skip via Stats.Skipped.
+func TestAutoTraceStats(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	var once sync.Once
+	tracer := &Tracer{
+		Key:     "treqs",
+		Trigger: func(*http.Request) bool { return true },
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			once.Do(func() {
+				close(started)
+				<-release
+			})
+		}),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		res, err := http.Get(srv.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+	}()
+
+	<-started // the first request is now holding t.mu inside autoTrace
+
+	go func() {
+		defer wg.Done()
+		// Loses the TryLock race (recorded as skipped), then falls
+		// back to an RLock that blocks until release is closed below.
+		res, err := http.Get(srv.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+	}()
+
+	// Give the second request a moment to reach & lose the TryLock
+	// race before the first one's handler returns.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "stats")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var stats Stats
+	if err := json.NewDecoder(res.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Skipped < 1 {
+		t.Fatalf("got Stats.Skipped = %d, want >= 1", stats.Skipped)
+	}
+}
@@ -1,17 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"time"
+
+	"github.com/benburkert/treqs"
 )
 
 var (
 	key    = flag.String("key", "treqs", "tracer key")
 	method = flag.String("method", "GET", "HTTP request method")
 	url    = flag.String("url", "", "request URL")
+
+	cert       = flag.String("cert", "", "client certificate file, for mTLS")
+	keyFile    = flag.String("key-file", "", "client private key file, for mTLS")
+	ca         = flag.String("ca", "", "CA certificate file used to verify the server, for mTLS")
+	secretFile = flag.String("secret-file", "", "HMAC secret file; when set, a signed token is sent as -key instead")
 )
 
 func main() {
@@ -21,14 +32,16 @@ func main() {
 		log.Fatal("missing -url argument")
 	}
 
+	client := httpClient()
+
 	req, err := http.NewRequest(*method, *url, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
 	req.Header.Set("X-Treqs-Action", "trace")
-	req.Header.Set("X-Treqs-Key", *key)
+	setKeyHeader(req, "trace")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := client.Do(req)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,12 +56,64 @@ func main() {
 	}
 	req.Header.Set("X-Treqs-Action", "read")
 	req.Header.Set("X-Treqs-Id", traceID)
-	req.Header.Set("X-Treqs-Key", *key)
+	setKeyHeader(req, "read")
 
-	if res, err = http.DefaultClient.Do(req); err != nil {
+	if res, err = client.Do(req); err != nil {
 		log.Fatal(err)
 	}
 	if _, err = io.Copy(os.Stdout, res.Body); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// httpClient builds an http.Client configured for mTLS when -cert or -ca
+// is set (-key-file is only read together with -cert); otherwise it
+// returns http.DefaultClient.
+func httpClient() *http.Client {
+	if *cert == "" && *ca == "" {
+		return http.DefaultClient
+	}
+
+	config := &tls.Config{}
+
+	if *cert != "" {
+		certificate, err := tls.LoadX509KeyPair(*cert, *keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if *ca != "" {
+		pem, err := os.ReadFile(*ca)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatal("treqs: could not parse -ca certificate")
+		}
+		config.RootCAs = pool
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+// setKeyHeader sets the X-Treqs-Key header on req. With -secret-file it
+// builds a short-lived HMAC token for action & req's path instead of
+// sending -key as-is, for use with a server-side treqs.HMACAuthenticator.
+func setKeyHeader(req *http.Request, action string) {
+	if *secretFile == "" {
+		req.Header.Set("X-Treqs-Key", *key)
+		return
+	}
+
+	secret, err := os.ReadFile(*secretFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token := treqs.HMACToken(bytes.TrimSpace(secret), action, req.URL.Path, time.Now())
+	req.Header.Set("X-Treqs-Key", token)
+}
@@ -0,0 +1,81 @@
+package treqs
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"sync"
+)
+
+// ProxyTracer is a http.Handler like Tracer, but traces the upstream
+// round-trip of a wrapped httputil.ReverseProxy instead of an
+// arbitrary http.Handler. It lets treqs sit in front of a process it
+// can't instrument directly, tracing any goroutines spawned by the
+// proxy's copy loops and the Transport's connection bookkeeping.
+// ProxyTracer shares Tracer's session handling, so it supports the
+// same actions (trace, read, reset, list, stats), Auth, Store, and
+// Trigger/PostTrigger automatic tracing.
+//
+//	proxy := httputil.NewSingleHostReverseProxy(upstream)
+//	tracer := &treqs.ProxyTracer{
+//		Key:   "secret-treqs-key",
+//		Proxy: proxy,
+//	}
+//	http.ListenAndServe(addr, tracer)
+type ProxyTracer struct {
+	Proxy *httputil.ReverseProxy
+
+	// Key, Auth, Store, Trigger, & PostTrigger mirror the
+	// identically-named Tracer fields; see Tracer's doc comment.
+	Key         string
+	Auth        Authenticator
+	Store       TraceStore
+	Trigger     func(*http.Request) bool
+	PostTrigger func(*http.Request, *ResponseSummary) bool
+
+	once   sync.Once
+	tracer Tracer
+}
+
+func (t *ProxyTracer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.once.Do(t.init)
+
+	t.tracer.ServeHTTP(w, r)
+}
+
+func (t *ProxyTracer) init() {
+	t.tracer.Key = t.Key
+	t.tracer.Auth = t.Auth
+	t.tracer.Store = t.Store
+	t.tracer.Trigger = t.Trigger
+	t.tracer.PostTrigger = t.PostTrigger
+	t.tracer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Tracer.trace sets the X-Treqs-Id response header before
+		// invoking the handler, once it's settled on an ID for this
+		// capture. Speculative auto-traces never set it, since the
+		// caller didn't ask for a trace and has no use for the ID.
+		if id := w.Header().Get(xTReqsID); id != "" {
+			t.proxyWithID(id).ServeHTTP(w, r)
+			return
+		}
+		t.Proxy.ServeHTTP(w, r)
+	})
+}
+
+// proxyWithID returns a shallow copy of the wrapped ReverseProxy whose
+// ModifyResponse injects the X-Treqs-Id header once the upstream
+// round-trip completes, chaining any ModifyResponse already configured
+// on Proxy. A shallow copy is used so concurrent untraced requests
+// keep using the unmodified Proxy.
+func (t *ProxyTracer) proxyWithID(id string) *httputil.ReverseProxy {
+	modify := t.Proxy.ModifyResponse
+
+	p := *t.Proxy
+	p.ModifyResponse = func(res *http.Response) error {
+		res.Header.Set(xTReqsID, id)
+		if modify != nil {
+			return modify(res)
+		}
+		return nil
+	}
+	return &p
+}
@@ -0,0 +1,124 @@
+package treqs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+)
+
+func TestProxyTracer(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := &ProxyTracer{
+		Key:   "treqs",
+		Proxy: httputil.NewSingleHostReverseProxy(upstreamURL),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "trace")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "upstream" {
+		t.Fatalf("got body %q, want %q", body, "upstream")
+	}
+
+	id := res.Header.Get(xTReqsID)
+	if id == "" {
+		t.Fatal("missing X-Treqs-Id header")
+	}
+
+	req, err = http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "read")
+	req.Header.Set(xTReqsID, id)
+
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || len(body) == 0 {
+		t.Fatalf("read trace %s: got status %d, body len %d", id, res.StatusCode, len(body))
+	}
+
+	// A plain passthrough request shouldn't be tagged with a trace ID.
+	if res, err = http.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got := res.Header.Get(xTReqsID); got != "" {
+		t.Fatalf("passthrough request got unexpected %s header: %q", xTReqsID, got)
+	}
+}
+
+func TestProxyTracerAuthFailure(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := &ProxyTracer{
+		Key:   "treqs",
+		Proxy: httputil.NewSingleHostReverseProxy(upstreamURL),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "wrong-key")
+	req.Header.Set(xTReqsAction, "reset")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unauthorized action falls through to passthrough, same as Tracer.
+	if string(body) != "upstream" {
+		t.Fatalf("got body %q, want %q", body, "upstream")
+	}
+}
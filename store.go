@@ -0,0 +1,219 @@
+package treqs
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TraceMeta describes a single captured trace, as returned by
+// TraceStore.List.
+type TraceMeta struct {
+	ID         string
+	Size       int64
+	CapturedAt time.Time
+	Method     string
+	Path       string
+	Status     int
+}
+
+// TraceStore persists the runtime trace captured for a request, keyed
+// by trace ID. Implementations must be safe for concurrent use.
+type TraceStore interface {
+	Put(meta TraceMeta, r io.Reader) error
+	Get(id string) (io.ReadCloser, error)
+	Delete(id string) error
+	List() ([]TraceMeta, error)
+}
+
+// MemStore is an in-memory TraceStore bounded by a maximum byte budget
+// and a per-entry TTL. When a Put would push the store over MaxBytes
+// the least-recently-used entries are evicted first. A zero MaxBytes
+// or TTL disables the corresponding limit.
+type MemStore struct {
+	MaxBytes int64
+	TTL      time.Duration
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+type memEntry struct {
+	meta TraceMeta
+	data []byte
+}
+
+func (s *MemStore) Put(meta TraceMeta, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+
+	if el, ok := s.entries[meta.ID]; ok {
+		s.removeElement(el)
+	}
+
+	for s.MaxBytes > 0 && s.size+int64(len(data)) > s.MaxBytes && s.ll.Len() > 0 {
+		s.removeElement(s.ll.Back())
+	}
+
+	meta.Size = int64(len(data))
+	el := s.ll.PushFront(&memEntry{meta: meta, data: data})
+	s.entries[meta.ID] = el
+	s.size += int64(len(data))
+
+	return nil
+}
+
+func (s *MemStore) Get(id string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	entry := el.Value.(*memEntry)
+	if s.expired(entry.meta) {
+		s.removeElement(el)
+		return nil, os.ErrNotExist
+	}
+
+	s.ll.MoveToFront(el)
+	return io.NopCloser(bytes.NewReader(entry.data)), nil
+}
+
+func (s *MemStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[id]; ok {
+		s.removeElement(el)
+	}
+	return nil
+}
+
+func (s *MemStore) List() ([]TraceMeta, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var metas []TraceMeta
+	for el := s.ll.Front(); el != nil; {
+		next := el.Next()
+
+		entry := el.Value.(*memEntry)
+		if s.expired(entry.meta) {
+			s.removeElement(el)
+		} else {
+			metas = append(metas, entry.meta)
+		}
+
+		el = next
+	}
+	return metas, nil
+}
+
+func (s *MemStore) init() {
+	if s.ll == nil {
+		s.ll = list.New()
+		s.entries = make(map[string]*list.Element)
+	}
+}
+
+func (s *MemStore) expired(meta TraceMeta) bool {
+	return s.TTL > 0 && time.Since(meta.CapturedAt) > s.TTL
+}
+
+func (s *MemStore) removeElement(el *list.Element) {
+	entry := el.Value.(*memEntry)
+	s.size -= int64(len(entry.data))
+	s.ll.Remove(el)
+	delete(s.entries, entry.meta.ID)
+}
+
+// FileStore is a filesystem-backed TraceStore. Each trace is written
+// to Dir as "<id>.trace", with a "<id>.json" sidecar file holding its
+// TraceMeta so List doesn't need to read the trace data itself.
+type FileStore struct {
+	Dir string
+}
+
+func (s *FileStore) Put(meta TraceMeta, r io.Reader) error {
+	f, err := os.Create(filepath.Join(s.Dir, meta.ID+".trace"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	meta.Size = n
+
+	mf, err := os.Create(filepath.Join(s.Dir, meta.ID+".json"))
+	if err != nil {
+		return err
+	}
+	defer mf.Close()
+
+	return json.NewEncoder(mf).Encode(meta)
+}
+
+func (s *FileStore) Get(id string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Dir, id+".trace"))
+}
+
+func (s *FileStore) Delete(id string) error {
+	if err := os.Remove(filepath.Join(s.Dir, id+".trace")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.Dir, id+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) List() ([]TraceMeta, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make([]TraceMeta, 0, len(matches))
+	for _, path := range matches {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			// Removed by a concurrent Delete/reset between the Glob
+			// and the Open; skip it rather than failing the batch.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var meta TraceMeta
+		err = json.NewDecoder(f).Decode(&meta)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		metas = append(metas, meta)
+	}
+
+	return metas, nil
+}
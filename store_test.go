@@ -0,0 +1,288 @@
+package treqs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemStoreEviction(t *testing.T) {
+	s := &MemStore{MaxBytes: 10}
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := s.Put(TraceMeta{ID: id, CapturedAt: time.Now()}, strings.NewReader("12345")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	metas, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 {
+		t.Fatalf("got %d entries, want 2 (MaxBytes should have evicted the oldest)", len(metas))
+	}
+
+	if _, err := s.Get("a"); err != os.ErrNotExist {
+		t.Fatalf("Get(%q) = %v, want os.ErrNotExist", "a", err)
+	}
+	if _, err := s.Get("c"); err != nil {
+		t.Fatalf("Get(%q) = %v, want nil", "c", err)
+	}
+}
+
+func TestMemStoreTTL(t *testing.T) {
+	s := &MemStore{TTL: time.Millisecond}
+
+	if err := s.Put(TraceMeta{ID: "a", CapturedAt: time.Now()}, strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := s.Get("a"); err != os.ErrNotExist {
+		t.Fatalf("Get(%q) = %v, want os.ErrNotExist once expired", "a", err)
+	}
+
+	metas, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 0 {
+		t.Fatalf("got %d entries, want 0 once expired", len(metas))
+	}
+}
+
+func TestMemStoreDelete(t *testing.T) {
+	s := &MemStore{}
+
+	if err := s.Put(TraceMeta{ID: "a", CapturedAt: time.Now()}, strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("a"); err != os.ErrNotExist {
+		t.Fatalf("Get(%q) = %v, want os.ErrNotExist", "a", err)
+	}
+	// Deleting an unknown ID is a no-op, not an error.
+	if err := s.Delete("missing"); err != nil {
+		t.Fatalf("Delete of unknown ID returned %v, want nil", err)
+	}
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileStore{Dir: dir}
+
+	meta := TraceMeta{ID: "a", CapturedAt: time.Now(), Method: "GET", Path: "/"}
+	if err := s.Put(meta, strings.NewReader("trace-bytes")); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := s.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.ReadFrom(rc)
+	rc.Close()
+	if buf.String() != "trace-bytes" {
+		t.Fatalf("got %q, want %q", buf.String(), "trace-bytes")
+	}
+
+	metas, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 1 || metas[0].ID != "a" || metas[0].Size != int64(len("trace-bytes")) {
+		t.Fatalf("got %+v, want a single entry for %q", metas, "a")
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("a"); !os.IsNotExist(err) {
+		t.Fatalf("Get(%q) after Delete = %v, want os.IsNotExist", "a", err)
+	}
+}
+
+// TestFileStoreListSkipsConcurrentlyDeletedEntries reproduces the race
+// between Glob and Open: a *.json sidecar can be removed by a
+// concurrent Delete after List globs it but before it's opened. List
+// must skip that entry instead of failing the whole call.
+func TestFileStoreListSkipsConcurrentlyDeletedEntries(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileStore{Dir: dir}
+
+	for _, id := range []string{"a", "b"} {
+		if err := s.Put(TraceMeta{ID: id, CapturedAt: time.Now()}, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Simulate a concurrent Delete racing with List: replace "a"'s
+	// sidecar with a dangling symlink so the Glob in List still matches
+	// it but the Open fails with a not-exist error.
+	if err := os.Remove(dir + "/a.json"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir+"/missing.json", dir+"/a.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	metas, err := s.List()
+	if err != nil {
+		t.Fatalf("List returned an error instead of skipping the removed entry: %v", err)
+	}
+	if len(metas) != 1 || metas[0].ID != "b" {
+		t.Fatalf("got %+v, want only %q", metas, "b")
+	}
+}
+
+// TestTracerWithStore drives a Tracer backed by a Store through the
+// trace, list, read, & reset actions over real HTTP, confirming the
+// Store is actually wired up end to end: list reports what was
+// traced, and reset purges the Store along with the in-memory map.
+func TestTracerWithStore(t *testing.T) {
+	store := &MemStore{}
+	tracer := &Tracer{
+		Key:     "treqs",
+		Store:   store,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "trace")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	id := res.Header.Get(xTReqsID)
+	if id == "" {
+		t.Fatal("missing X-Treqs-Id header")
+	}
+
+	metas := doList(t, srv.URL)
+	if len(metas) != 1 || metas[0].ID != id {
+		t.Fatalf("got %+v after trace, want a single entry for %q", metas, id)
+	}
+
+	req, err = http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "read")
+	req.Header.Set(xTReqsID, id)
+
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || len(body) == 0 {
+		t.Fatalf("read trace %s: got status %d, body len %d", id, res.StatusCode, len(body))
+	}
+
+	req, err = http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "reset")
+
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if storeMetas, err := store.List(); err != nil {
+		t.Fatal(err)
+	} else if len(storeMetas) != 0 {
+		t.Fatalf("Store still has %d entries after reset, want 0", len(storeMetas))
+	}
+
+	if metas := doList(t, srv.URL); len(metas) != 0 {
+		t.Fatalf("list still reports %+v after reset, want none", metas)
+	}
+}
+
+func doList(t *testing.T, url string) []TraceMeta {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "list")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var metas []TraceMeta
+	if err := json.NewDecoder(res.Body).Decode(&metas); err != nil {
+		t.Fatal(err)
+	}
+	return metas
+}
+
+type errStore struct{}
+
+func (errStore) Put(TraceMeta, io.Reader) error    { return nil }
+func (errStore) Get(string) (io.ReadCloser, error) { return nil, os.ErrNotExist }
+func (errStore) Delete(string) error               { return nil }
+func (errStore) List() ([]TraceMeta, error)        { return nil, errors.New("store: boom") }
+
+// TestTracerListStoreError confirms the list action surfaces a
+// failing Store.List as a 500, rather than silently returning an
+// empty or partial result.
+func TestTracerListStoreError(t *testing.T) {
+	tracer := &Tracer{
+		Key:     "treqs",
+		Store:   errStore{},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "list")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("got status %d, want 500", res.StatusCode)
+	}
+}
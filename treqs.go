@@ -60,36 +60,153 @@
 //		tracer.Exclude(app.Compact)
 //	}
 //
+// A trace request can also capture a bundle of pprof profiles alongside
+// the runtime trace by setting the X-Treqs-Profiles header to a
+// comma-separated list of profile names (cpu, heap, goroutine, block,
+// mutex, allocs). Each profile is retrieved with the read action by
+// setting the X-Treqs-Profile header to the profile name; it defaults
+// to the runtime trace itself.
+//
+//	req.Header.Set("X-Treqs-Action", "trace")
+//	req.Header.Set("X-Treqs-Key", "secret-treqs-key")
+//	req.Header.Set("X-Treqs-Profiles", "cpu,heap")
+//
+//	req.Header.Set("X-Treqs-Action", "read")
+//	req.Header.Set("X-Treqs-Id", traceID)
+//	req.Header.Set("X-Treqs-Key", "secret-treqs-key")
+//	req.Header.Set("X-Treqs-Profile", "heap")
+//
+// By default traces only live in memory for the life of the process.
+// Set Store to persist them instead, and use the list action to
+// discover trace IDs that were captured but never read back.
+//
+//	tracer := &treqs.Tracer{
+//		Key:     "secret-treqs-key",
+//		Handler: handler,
+//		Store:   &treqs.FileStore{Dir: "/var/lib/treqs"},
+//	}
+//
+//	req.Header.Set("X-Treqs-Action", "list")
+//	req.Header.Set("X-Treqs-Key", "secret-treqs-key")
+//
+// Set Trigger & PostTrigger to capture traces automatically instead of
+// waiting for a client to ask for one. Trigger decides, from the
+// incoming request alone, whether to start a speculative trace;
+// PostTrigger then decides, once the response is known, whether to
+// keep it. Because runtime/trace only supports one trace at a time,
+// a Trigger match is skipped while any other request holds the lock
+// (a trace, or requests served normally); the count of skips is
+// available via the stats action.
+//
+//	tracer := &treqs.Tracer{
+//		Key:         "secret-treqs-key",
+//		Handler:     handler,
+//		Trigger:     treqs.RateSampler(10, time.Minute),
+//		PostTrigger: treqs.LatencyTrigger(500 * time.Millisecond),
+//	}
+//
+// The Key field sends a shared secret in plaintext on every request,
+// which is only safe on localhost. Set Auth instead to authorize
+// requests with a short-lived HMAC token (HMACAuthenticator) or a
+// client certificate (MTLSAuthenticator).
+//
+//	tracer := &treqs.Tracer{
+//		Handler: handler,
+//		Auth:    &treqs.HMACAuthenticator{Secret: secret},
+//	}
+//
 package treqs
 
 import (
 	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"runtime"
+	"runtime/pprof"
 	"runtime/trace"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	xTReqsAction   = "X-Treqs-Action"
+	xTReqsID       = "X-Treqs-Id"
+	xTReqsKey      = "X-Treqs-Key"
+	xTReqsProfile  = "X-Treqs-Profile"
+	xTReqsProfiles = "X-Treqs-Profiles"
 )
 
+// Profile names accepted by the X-Treqs-Profiles & X-Treqs-Profile
+// headers. profTrace identifies the runtime/trace data and is always
+// captured.
 const (
-	xTReqsAction = "X-Treqs-Action"
-	xTReqsID     = "X-Treqs-Id"
-	xTReqsKey    = "X-Treqs-Key"
+	profTrace     = "trace"
+	profCPU       = "cpu"
+	profHeap      = "heap"
+	profGoroutine = "goroutine"
+	profBlock     = "block"
+	profMutex     = "mutex"
+	profAllocs    = "allocs"
 )
 
+// profileBundle holds the runtime trace together with any pprof
+// profiles captured for the lifetime of a single traced request, keyed
+// by profile name.
+type profileBundle map[string]*bytes.Buffer
+
+func newProfileBundle() profileBundle {
+	return make(profileBundle)
+}
+
+func (b profileBundle) buf(name string) *bytes.Buffer {
+	buf, ok := b[name]
+	if !ok {
+		buf = bytes.NewBuffer(nil)
+		b[name] = buf
+	}
+	return buf
+}
+
 // Tracer is a http.Handler for enabling runtime tracing on a wrapped
-// http.Handler. The handler supports three actions: trace, read, &
-// reset.
+// http.Handler. The handler supports five actions: trace, read, reset,
+// list, & stats.
 type Tracer struct {
 	http.Handler
 
+	// Key is the shared secret checked against the X-Treqs-Key header.
+	// It's only consulted when Auth is nil, via StaticKeyAuthenticator.
 	Key string
 
-	mu     sync.RWMutex
-	traces map[string]*bytes.Buffer
+	// Auth, when set, replaces the static-key check with an arbitrary
+	// Authenticator (e.g. HMACAuthenticator or MTLSAuthenticator).
+	Auth Authenticator
+
+	// Store keeps the captured runtime trace for each request so it can
+	// be listed with the list action; a FileStore also keeps it across a
+	// restart, a MemStore does not. If nil traces only live in memory
+	// for the life of the process.
+	Store TraceStore
+
+	// Trigger, when set, is consulted for every request that doesn't
+	// carry an authorized action (trace, read, reset, list, or stats).
+	// A match starts a speculative trace for the request.
+	Trigger func(*http.Request) bool
+
+	// PostTrigger decides whether a speculative trace started by
+	// Trigger is kept once the response completes. It's ignored if
+	// Trigger is nil, and a nil PostTrigger discards every speculative
+	// trace.
+	PostTrigger func(*http.Request, *ResponseSummary) bool
+
+	mu      sync.RWMutex
+	traces  map[string]profileBundle
+	skipped atomic.Uint64
 }
 
 // Exclude prevents the func from inclusion in a trace.
@@ -100,67 +217,207 @@ func (t *Tracer) Exclude(fn func()) {
 	fn()
 }
 
+func (t *Tracer) authenticator() Authenticator {
+	if t.Auth != nil {
+		return t.Auth
+	}
+	return StaticKeyAuthenticator(t.Key)
+}
+
 func (t *Tracer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	key, action, id := scrubHeader(r.Header)
+	key, rawAction, id, profile, profiles := scrubHeader(r.Header)
+	action := strings.ToLower(rawAction)
 
-	if key != t.Key {
+	if err := t.authenticator().Authorize(r, action, key); err != nil {
 		action = ""
 	}
 
-	switch strings.ToLower(action) {
+	switch action {
 	default:
+		if t.Trigger != nil && t.Trigger(r) {
+			t.autoTrace(w, r)
+			return
+		}
+
 		t.mu.RLock()
 		defer t.mu.RUnlock()
 
 		t.Handler.ServeHTTP(w, r)
+	case "list":
+		t.list(w, r)
 	case "read":
-		t.read(id, w, r)
+		t.read(id, profile, w, r)
 	case "reset":
 		t.reset(w, r)
+	case "stats":
+		t.stats(w, r)
 	case "trace":
-		t.trace(w, r)
+		t.trace(profiles, w, r)
 	}
 }
 
-func (t *Tracer) read(id string, w http.ResponseWriter, r *http.Request) {
+func (t *Tracer) read(id, profile string, w http.ResponseWriter, r *http.Request) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	buf, ok := t.traces[id]
-	if !ok {
+	if profile == "" {
+		profile = profTrace
+	}
+
+	if bundle, ok := t.traces[id]; ok {
+		if buf, ok := bundle[profile]; ok {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(buf.Bytes())
+			return
+		}
+	}
+
+	// Fall back to the store for the main trace: it's the one artifact
+	// that outlives the process, which is the whole point of a trace ID
+	// discovered via the list action after a restart.
+	if profile != profTrace || t.Store == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	rc, err := t.Store.Get(id)
+	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
+	defer rc.Close()
 
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Write(buf.Bytes())
+	io.Copy(w, rc)
 }
 
 func (t *Tracer) reset(w http.ResponseWriter, r *http.Request) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	t.traces = make(map[string]*bytes.Buffer)
+	if t.Store != nil {
+		if metas, err := t.Store.List(); err == nil {
+			for _, meta := range metas {
+				t.Store.Delete(meta.ID)
+			}
+		}
+	}
+
+	t.traces = make(map[string]profileBundle)
 }
 
-func (t *Tracer) trace(w http.ResponseWriter, r *http.Request) {
+func (t *Tracer) list(w http.ResponseWriter, r *http.Request) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var metas []TraceMeta
+	if t.Store != nil {
+		var err error
+		if metas, err = t.Store.List(); err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Could not list traces: %s\n", err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metas)
+}
+
+func (t *Tracer) trace(profiles string, w http.ResponseWriter, r *http.Request) {
+	wanted := parseProfiles(profiles)
+
+	// The write lock is held for the life of the request, not just a
+	// handshake: runtime/trace & pprof.StartCPUProfile are both
+	// process-wide singletons, so a second concurrent trace of any
+	// kind would either corrupt this one or fail outright, and the
+	// package doc promises that only the single traced request runs
+	// while tracing is enabled.
 	t.mu.Lock()
-	t.mu.Unlock()
+	defer t.mu.Unlock()
+
+	if t.traces == nil {
+		t.traces = make(map[string]profileBundle)
+	}
 
-	id, buf := randHex(), bytes.NewBuffer(nil)
+	id, bundle := randHex(), newProfileBundle()
 	w.Header().Set(xTReqsID, id)
 
-	if err := trace.Start(buf); err != nil {
+	if wanted[profBlock] {
+		runtime.SetBlockProfileRate(1)
+		defer runtime.SetBlockProfileRate(0)
+	}
+	if wanted[profMutex] {
+		runtime.SetMutexProfileFraction(1)
+		defer runtime.SetMutexProfileFraction(0)
+	}
+	if wanted[profCPU] {
+		if err := pprof.StartCPUProfile(bundle.buf(profCPU)); err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Could not enable cpu profiling: %s\n", err)
+			return
+		}
+	}
+
+	if err := trace.Start(bundle.buf(profTrace)); err != nil {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Could not enable tracing: %s\n", err)
 		return
 	}
 
-	t.Handler.ServeHTTP(w, r)
+	capturedAt := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	t.Handler.ServeHTTP(sw, r)
+
 	trace.Stop()
+	if wanted[profCPU] {
+		pprof.StopCPUProfile()
+	}
+	for _, name := range []string{profHeap, profGoroutine, profBlock, profMutex, profAllocs} {
+		if wanted[name] {
+			pprof.Lookup(name).WriteTo(bundle.buf(name), 0)
+		}
+	}
+
+	t.traces[id] = bundle
 
-	t.traces[id] = buf
+	if t.Store != nil {
+		meta := TraceMeta{
+			ID:         id,
+			CapturedAt: capturedAt,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     sw.status,
+		}
+		t.Store.Put(meta, bytes.NewReader(bundle[profTrace].Bytes()))
+	}
+}
+
+// statusWriter records the status code written through a
+// http.ResponseWriter so it can be captured in a trace's TraceMeta.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// parseProfiles splits a comma-separated X-Treqs-Profiles header value
+// into a set of normalized profile names.
+func parseProfiles(hdr string) map[string]bool {
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(hdr, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			wanted[name] = true
+		}
+	}
+	return wanted
 }
 
 func randHex() string {
@@ -172,7 +429,7 @@ func randHex() string {
 	return hex.EncodeToString(b)
 }
 
-func scrubHeader(hdr http.Header) (key, action, id string) {
+func scrubHeader(hdr http.Header) (key, action, id, profile, profiles string) {
 	for k := range hdr {
 		switch k {
 		case xTReqsKey:
@@ -187,6 +444,14 @@ func scrubHeader(hdr http.Header) (key, action, id string) {
 			if id == "" {
 				id = hdr.Get(k)
 			}
+		case xTReqsProfile:
+			if profile == "" {
+				profile = hdr.Get(k)
+			}
+		case xTReqsProfiles:
+			if profiles == "" {
+				profiles = hdr.Get(k)
+			}
 		}
 
 		delete(hdr, k)
@@ -8,6 +8,10 @@ import (
 	"net/http/httptest"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 )
 
 func ExampleTracer() {
@@ -80,3 +84,207 @@ func ExampleTracer() {
 
 	// run "go tool trace pi.trace" to view the trace in Chrome
 }
+
+func TestTracerProfileBundle(t *testing.T) {
+	tracer := &Tracer{
+		Key:     "treqs",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "trace")
+	req.Header.Set(xTReqsProfiles, "heap,goroutine")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	id := res.Header.Get(xTReqsID)
+	if id == "" {
+		t.Fatal("missing X-Treqs-Id header")
+	}
+
+	for _, profile := range []string{profTrace, profHeap, profGoroutine} {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(xTReqsKey, "treqs")
+		req.Header.Set(xTReqsAction, "read")
+		req.Header.Set(xTReqsID, id)
+		req.Header.Set(xTReqsProfile, profile)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("profile %q: got status %d, want 200", profile, res.StatusCode)
+		}
+		if len(body) == 0 {
+			t.Fatalf("profile %q: empty body", profile)
+		}
+	}
+
+	req, err = http.NewRequest("GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(xTReqsKey, "treqs")
+	req.Header.Set(xTReqsAction, "read")
+	req.Header.Set(xTReqsID, id)
+	req.Header.Set(xTReqsProfile, profCPU)
+
+	if res, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("unrequested profile %q: got status %d, want 404", profCPU, res.StatusCode)
+	}
+}
+
+// TestTracerProfileBundleCPUBlockMutex covers the profile mix that
+// toggles process-global rates (SetBlockProfileRate,
+// SetMutexProfileFraction, StartCPUProfile). Four of these captures
+// run concurrently: if the exclusive lock were released before the
+// handler ran, pprof.StartCPUProfile's process-wide singleton would make at least
+// one of them fail with a 500 instead of serializing cleanly. It then
+// reads each captured profile back.
+func TestTracerProfileBundleCPUBlockMutex(t *testing.T) {
+	var active int32
+	tracer := &Tracer{
+		Key: "treqs",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n := atomic.AddInt32(&active, 1); n > 1 {
+				t.Errorf("got %d overlapping cpu/block/mutex captures, want at most 1", n)
+			}
+			defer atomic.AddInt32(&active, -1)
+
+			time.Sleep(5 * time.Millisecond)
+		}),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	ids := make([]string, 4)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Set(xTReqsKey, "treqs")
+			req.Header.Set(xTReqsAction, "trace")
+			req.Header.Set(xTReqsProfiles, "cpu,block,mutex")
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("concurrent cpu/block/mutex trace %d: got status %d, want 200", i, res.StatusCode)
+				return
+			}
+			ids[i] = res.Header.Get(xTReqsID)
+		}(i)
+	}
+	wg.Wait()
+
+	id := ids[0]
+	if id == "" {
+		t.Fatal("missing X-Treqs-Id header")
+	}
+
+	for _, profile := range []string{profCPU, profBlock, profMutex} {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(xTReqsKey, "treqs")
+		req.Header.Set(xTReqsAction, "read")
+		req.Header.Set(xTReqsID, id)
+		req.Header.Set(xTReqsProfile, profile)
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("profile %q: got status %d, want 200", profile, res.StatusCode)
+		}
+		if len(body) == 0 {
+			t.Fatalf("profile %q: empty body", profile)
+		}
+	}
+}
+
+func TestTracerConcurrentTraceSerialized(t *testing.T) {
+	var active int32
+	tracer := &Tracer{
+		Key: "treqs",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n := active + 1; n > 1 {
+				t.Errorf("got %d overlapping traced requests, want at most 1", n)
+			}
+			active++
+			defer func() { active-- }()
+		}),
+	}
+	srv := httptest.NewServer(tracer)
+	defer srv.Close()
+
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Error(err)
+				done <- struct{}{}
+				return
+			}
+			req.Header.Set(xTReqsKey, "treqs")
+			req.Header.Set(xTReqsAction, "trace")
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				done <- struct{}{}
+				return
+			}
+			res.Body.Close()
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+}